@@ -0,0 +1,181 @@
+package awsranges
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is returned by a Cache's Load when no cached response exists
+// yet.
+var ErrCacheMiss = errors.New("awsranges: cache miss")
+
+// CacheMeta carries the metadata a Cache stores alongside the raw
+// ip-ranges.json bytes, so refreshes can be conditional and staleness can be
+// judged without re-parsing the payload.
+type CacheMeta struct {
+	ETag         string
+	LastModified string
+	SyncToken    string
+	FetchedAt    time.Time
+}
+
+// Cache is a pluggable backend for the last-fetched ip-ranges.json response.
+type Cache interface {
+	// Load returns the cached response and its metadata, or ErrCacheMiss if
+	// nothing has been cached yet.
+	Load(ctx context.Context) ([]byte, CacheMeta, error)
+
+	// Store persists data and meta, replacing whatever was cached before.
+	Store(ctx context.Context, data []byte, meta CacheMeta) error
+}
+
+// dataFileName and metaFileName are the file names FileCache stores within
+// its directory.
+const (
+	dataFileName string = "ip-ranges.json"
+	metaFileName string = "ip-ranges.meta.json"
+)
+
+// FileCache is the default Cache, backed by two files in Dir: the raw
+// ip-ranges.json response and a small sidecar of CacheMeta. Writes go
+// through a temp file and os.Rename so a reader never observes a partial
+// file.
+type FileCache struct {
+	// Dir is the directory the cache files live in. Use DefaultCacheDir to
+	// get an XDG-compliant default.
+	Dir string
+}
+
+// NewFileCache returns a FileCache rooted at dir. If dir is empty,
+// DefaultCacheDir is used.
+func NewFileCache(dir string) (*FileCache, error) {
+	if dir == "" {
+		var err error
+		dir, err = DefaultCacheDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileCache{Dir: dir}, nil
+}
+
+// DefaultCacheDir returns $XDG_CACHE_HOME/awsranges if XDG_CACHE_HOME is
+// set, otherwise ~/.cache/awsranges.
+func DefaultCacheDir() (string, error) {
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "awsranges"), nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(u.HomeDir, ".cache", "awsranges"), nil
+}
+
+// Load implements Cache.
+func (c *FileCache) Load(ctx context.Context) ([]byte, CacheMeta, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir, dataFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, CacheMeta{}, ErrCacheMiss
+		}
+		return nil, CacheMeta{}, err
+	}
+
+	var meta CacheMeta
+	metaData, err := ioutil.ReadFile(filepath.Join(c.Dir, metaFileName))
+	if err == nil {
+		if err := json.Unmarshal(metaData, &meta); err != nil {
+			return nil, CacheMeta{}, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, CacheMeta{}, err
+	}
+
+	return data, meta, nil
+}
+
+// Store implements Cache.
+func (c *FileCache) Store(ctx context.Context, data []byte, meta CacheMeta) error {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if err := atomicWriteFile(filepath.Join(c.Dir, dataFileName), data); err != nil {
+		return err
+	}
+	return atomicWriteFile(filepath.Join(c.Dir, metaFileName), metaData)
+}
+
+// atomicWriteFile writes data to a temp file in the same directory as path,
+// then renames it into place so concurrent readers never see a partial
+// write.
+func atomicWriteFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, 0600); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+// MemoryCache is an in-memory Cache, primarily useful for tests and for
+// environments without a writable filesystem.
+type MemoryCache struct {
+	mu   sync.Mutex
+	data []byte
+	meta CacheMeta
+	set  bool
+}
+
+// Load implements Cache.
+func (c *MemoryCache) Load(ctx context.Context) ([]byte, CacheMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.set {
+		return nil, CacheMeta{}, ErrCacheMiss
+	}
+	return c.data, c.meta, nil
+}
+
+// Store implements Cache.
+func (c *MemoryCache) Store(ctx context.Context, data []byte, meta CacheMeta) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data = data
+	c.meta = meta
+	c.set = true
+	return nil
+}
@@ -0,0 +1,89 @@
+package awsranges
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheRoundTrip(t *testing.T) {
+	mc := &MemoryCache{}
+
+	if _, _, err := mc.Load(context.Background()); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Load on an empty MemoryCache: err = %v, want ErrCacheMiss", err)
+	}
+
+	want := CacheMeta{ETag: `"v1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", SyncToken: "1", FetchedAt: time.Now()}
+	if err := mc.Store(context.Background(), []byte(`{"syncToken":"1"}`), want); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	data, meta, err := mc.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(data) != `{"syncToken":"1"}` {
+		t.Errorf("data = %q, want %q", data, `{"syncToken":"1"}`)
+	}
+	if meta != want {
+		t.Errorf("meta = %+v, want %+v", meta, want)
+	}
+}
+
+func TestFileCacheRoundTrip(t *testing.T) {
+	fc, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCache: %v", err)
+	}
+
+	if _, _, err := fc.Load(context.Background()); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Load on an empty FileCache: err = %v, want ErrCacheMiss", err)
+	}
+
+	want := CacheMeta{ETag: `"v1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", SyncToken: "1", FetchedAt: time.Now().Truncate(time.Second)}
+	if err := fc.Store(context.Background(), []byte(`{"syncToken":"1"}`), want); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	data, meta, err := fc.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(data) != `{"syncToken":"1"}` {
+		t.Errorf("data = %q, want %q", data, `{"syncToken":"1"}`)
+	}
+	if !meta.FetchedAt.Equal(want.FetchedAt) || meta.ETag != want.ETag || meta.LastModified != want.LastModified || meta.SyncToken != want.SyncToken {
+		t.Errorf("meta = %+v, want %+v", meta, want)
+	}
+}
+
+func TestFileCacheStoreOverwrites(t *testing.T) {
+	fc, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCache: %v", err)
+	}
+
+	if err := fc.Store(context.Background(), []byte(`{"syncToken":"1"}`), CacheMeta{SyncToken: "1"}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := fc.Store(context.Background(), []byte(`{"syncToken":"2"}`), CacheMeta{SyncToken: "2"}); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	data, meta, err := fc.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if string(data) != `{"syncToken":"2"}` || meta.SyncToken != "2" {
+		t.Errorf("got data=%q meta=%+v, want the second Store's values", data, meta)
+	}
+}
+
+func TestNewFileCacheCreatesDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "cache")
+	if _, err := NewFileCache(dir); err != nil {
+		t.Fatalf("NewFileCache: %v", err)
+	}
+}
@@ -8,6 +8,9 @@ import (
 )
 
 var (
+	ipRegions  []string
+	ipServices []string
+
 	IPCmd = &cobra.Command{
 		Use:   "check-ip",
 		Short: "Check if an IP address or network belongs to AWS",
@@ -22,18 +25,25 @@ var (
 	}
 )
 
+func init() {
+	IPCmd.Flags().StringSliceVar(&ipRegions, "region", nil, "restrict the check to these regions (supports wildcards, e.g. us-*)")
+	IPCmd.Flags().StringSliceVar(&ipServices, "service", nil, "restrict the check to these services (e.g. S3, EC2, CLOUDFRONT)")
+}
+
 func checkIP(addr string) (bool, error) {
-	ranges, err := awsranges.New()
+	ranges, err := awsranges.NewWithOptions(awsranges.Options{Regions: ipRegions, Services: ipServices})
 	if err != nil {
 		return false, err
 	}
+	defer ranges.Close()
 	return ranges.CheckAddress(addr)
 }
 
 func checkCIDR(addr string) (bool, error) {
-	ranges, err := awsranges.New()
+	ranges, err := awsranges.NewWithOptions(awsranges.Options{Regions: ipRegions, Services: ipServices})
 	if err != nil {
 		return false, err
 	}
+	defer ranges.Close()
 	return ranges.CheckCIDR(addr)
 }
@@ -9,6 +9,9 @@ import (
 )
 
 var (
+	svcsRegions  []string
+	svcsServices []string
+
 	SvcsCmd = &cobra.Command{
 		Use:   "check-services",
 		Short: "Check which AWS services an IP address or network belongs to",
@@ -18,11 +21,18 @@ var (
 	}
 )
 
+func init() {
+	SvcsCmd.Flags().StringSliceVar(&svcsRegions, "region", nil, "restrict the check to these regions (supports wildcards, e.g. us-*)")
+	SvcsCmd.Flags().StringSliceVar(&svcsServices, "service", nil, "restrict the check to these services (e.g. S3, EC2, CLOUDFRONT)")
+}
+
 func checkServices(addr string) error {
-	ranges, err := awsranges.New()
+	ranges, err := awsranges.NewWithOptions(awsranges.Options{Regions: svcsRegions, Services: svcsServices})
 	if err != nil {
 		return err
 	}
+	defer ranges.Close()
+
 	resp, err := ranges.CheckServices(addr)
 	if err != nil {
 		fmt.Println(err)
@@ -0,0 +1,165 @@
+// Package httpmw provides an HTTP middleware built on top of awsranges that
+// allows, denies, or tags requests based on whether the client's source IP
+// belongs to AWS.
+package httpmw
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lukekalbfleisch/awsranges"
+)
+
+// MiddlewareOptions configures Middleware.
+type MiddlewareOptions struct {
+	// TrustedProxies lists the CIDRs (or bare IPs) of proxies allowed to
+	// set X-Forwarded-For/X-Real-IP. If empty, those headers are ignored
+	// and only the request's own RemoteAddr is considered, which is the
+	// safe default when the app is reachable directly.
+	TrustedProxies []string
+
+	// AllowServices, if non-empty, allows only requests whose source IP
+	// belongs to one of these AWS services.
+	AllowServices []string
+
+	// DenyServices, if non-empty, rejects requests whose source IP belongs
+	// to one of these AWS services, even if AllowServices would otherwise
+	// allow it.
+	DenyServices []string
+
+	// AllowRegions, if non-empty, allows only requests whose source IP
+	// belongs to AWS in one of these regions.
+	AllowRegions []string
+
+	// OnMatch, if set, is called for every allowed request whose source IP
+	// belongs to AWS, just before it is passed to the next handler. It is
+	// not called for requests that are denied.
+	OnMatch func(*http.Request, awsranges.ServicesResponse)
+}
+
+// Middleware returns HTTP middleware that inspects the client IP of each
+// request against r and allows, denies, or tags the request according to
+// opts. A request whose source IP isn't in AWS at all is allowed unless
+// AllowServices or AllowRegions is set, in which case AWS origin becomes
+// mandatory.
+func Middleware(r *awsranges.Ranges, opts MiddlewareOptions) func(http.Handler) http.Handler {
+	trustedProxies := parseTrustedProxies(opts.TrustedProxies)
+	requireAWS := len(opts.AllowServices) > 0 || len(opts.AllowRegions) > 0
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			addr := clientIP(req, trustedProxies)
+
+			resp, err := r.CheckServices(addr)
+			if err != nil || resp == nil || len(resp.Services) == 0 {
+				if requireAWS {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			if containsAny(opts.DenyServices, resp.Services) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if len(opts.AllowServices) > 0 && !containsAny(opts.AllowServices, resp.Services) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			if len(opts.AllowRegions) > 0 && !contains(opts.AllowRegions, resp.Region) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			if opts.OnMatch != nil {
+				opts.OnMatch(req, *resp)
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// clientIP determines the request's client IP. X-Forwarded-For and
+// X-Real-IP are only honored when the immediate peer (RemoteAddr) is a
+// trusted proxy; otherwise RemoteAddr is used as-is.
+func clientIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	remoteIP := remoteAddrIP(req.RemoteAddr)
+
+	if !isTrusted(remoteIP, trustedProxies) {
+		return remoteIP
+	}
+
+	if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+		if first := strings.TrimSpace(strings.Split(fwd, ",")[0]); first != "" {
+			return first
+		}
+	}
+	if real := req.Header.Get("X-Real-IP"); real != "" {
+		return strings.TrimSpace(real)
+	}
+
+	return remoteIP
+}
+
+func remoteAddrIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func isTrusted(ipStr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil {
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				p = p + "/" + strconv.Itoa(bits)
+			}
+		}
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+func contains(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, targets []string) bool {
+	for _, t := range targets {
+		if contains(values, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package httpmw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lukekalbfleisch/awsranges"
+)
+
+const testRangesJSON = `{
+	"syncToken": "1",
+	"prefixes": [
+		{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+		{"ip_prefix": "3.5.140.0/24", "region": "ap-northeast-2", "service": "EC2"}
+	],
+	"ipv6_prefixes": []
+}`
+
+func newTestRanges(t *testing.T) *awsranges.Ranges {
+	t.Helper()
+	r, err := awsranges.NewFromJSON([]byte(testRangesJSON), awsranges.Options{})
+	if err != nil {
+		t.Fatalf("NewFromJSON: %v", err)
+	}
+	return r
+}
+
+func doRequest(handler http.Handler, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestMiddlewareDenyServicesBlocksOnAnyEnclosingMatch(t *testing.T) {
+	r := newTestRanges(t)
+	// 3.5.140.5 is in AWS under both the broad AMAZON block and the narrower
+	// EC2 sub-block; denying AMAZON must block it even though a more
+	// specific EC2 match also applies.
+	handler := Middleware(r, MiddlewareOptions{DenyServices: []string{"AMAZON"}})(okHandler())
+
+	rec := doRequest(handler, "3.5.140.5:1234", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestMiddlewareAllowServicesRequiresAWSOrigin(t *testing.T) {
+	r := newTestRanges(t)
+	handler := Middleware(r, MiddlewareOptions{AllowServices: []string{"EC2"}})(okHandler())
+
+	rec := doRequest(handler, "3.5.140.5:1234", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("AWS address: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = doRequest(handler, "8.8.8.8:1234", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("non-AWS address: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestMiddlewareAllowsNonAWSByDefault(t *testing.T) {
+	r := newTestRanges(t)
+	handler := Middleware(r, MiddlewareOptions{})(okHandler())
+
+	rec := doRequest(handler, "8.8.8.8:1234", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMiddlewareTrustedProxyForwarding(t *testing.T) {
+	r := newTestRanges(t)
+	handler := Middleware(r, MiddlewareOptions{
+		TrustedProxies: []string{"127.0.0.1"},
+		DenyServices:   []string{"AMAZON"},
+	})(okHandler())
+
+	// A trusted proxy's forwarded client IP is honored...
+	rec := doRequest(handler, "127.0.0.1:1234", map[string]string{"X-Forwarded-For": "3.5.140.5"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("trusted proxy: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// ...but an untrusted peer's forwarded header is ignored in favor of its
+	// own RemoteAddr.
+	rec = doRequest(handler, "203.0.113.9:1234", map[string]string{"X-Forwarded-For": "3.5.140.5"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("untrusted peer: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -1,69 +1,217 @@
 package awsranges
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
-	"os/user"
 	"path"
-	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	awsRangesURL  string = "https://ip-ranges.amazonaws.com/ip-ranges.json"
-	cacheFileName string = ".aws-ranges.json"
+	awsRangesURL string = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+
+	defaultRefreshInterval time.Duration = 12 * time.Hour
+
+	// createDateLayout matches the non-standard timestamp format AWS uses
+	// for the top-level createDate field, e.g. "2023-01-02-03-04-05".
+	createDateLayout string = "2006-01-02-15-04-05"
 )
 
 // Prefix is a representation of given IP prefix, region and service
 type Prefix struct {
-	IP      string `json:"ip_prefix"`
-	Region  string
-	Service string
+	IP                 string `json:"ip_prefix"`
+	Region             string `json:"region"`
+	Service            string `json:"service"`
+	NetworkBorderGroup string `json:"network_border_group"`
+}
+
+// ipv6Prefix mirrors Prefix but matches the "ipv6_prefix" key used in the
+// ipv6_prefixes table of ip-ranges.json.
+type ipv6Prefix struct {
+	IP                 string `json:"ipv6_prefix"`
+	Region             string `json:"region"`
+	Service            string `json:"service"`
+	NetworkBorderGroup string `json:"network_border_group"`
+}
+
+// Options configures a Ranges instance created via NewWithOptions.
+type Options struct {
+	// RefreshInterval controls how often the background updater re-fetches
+	// ip-ranges.json. Defaults to 12h if zero or negative.
+	RefreshInterval time.Duration
+
+	// CacheDir overrides the directory the default FileCache stores its
+	// files in. Ignored if Cache is set. Defaults to an XDG-compliant cache
+	// directory (see DefaultCacheDir).
+	CacheDir string
+
+	// Cache overrides how the last-fetched ip-ranges.json response is
+	// persisted between refreshes. Defaults to a FileCache rooted at
+	// CacheDir.
+	Cache Cache
+
+	// MaxCacheAge bounds how old a cached response can be before it's
+	// considered stale and a network refresh is forced on startup, rather
+	// than trusting the cache indefinitely. Defaults to twice
+	// RefreshInterval.
+	MaxCacheAge time.Duration
+
+	// HTTPClient overrides the client used to fetch ip-ranges.json.
+	HTTPClient *http.Client
+
+	// Regions, if non-empty, restricts the loaded prefixes to the given
+	// regions. Entries may use path.Match-style wildcards, e.g. "us-*".
+	Regions []string
+
+	// Services, if non-empty, restricts the loaded prefixes to the given
+	// services, e.g. "S3", "EC2", "CLOUDFRONT".
+	Services []string
+
+	// NetworkBorderGroups, if non-empty, restricts the loaded prefixes to
+	// the given network border groups.
+	NetworkBorderGroups []string
 }
 
 // Ranges contains the entire list of AWS Prefixes and an HTTP client
 // used to pull data down from AWS
 type Ranges struct {
-	Prefixes []Prefix
-	Client   *http.Client
+	IPv4Prefixes []Prefix
+	IPv6Prefixes []Prefix
+	SyncToken    string
+	CreateDate   time.Time
+	Client       *http.Client
+
+	mu          sync.RWMutex
+	opts        Options
+	cache       Cache
+	cacheMeta   CacheMeta
+	stopRefresh chan struct{}
+	wg          sync.WaitGroup
+
+	v4Trie *prefixTrie
+	v6Trie *prefixTrie
 }
 
-// CheckAddress checks if a given address is owned by AWS
-func (r *Ranges) CheckAddress(address string) (bool, error) {
-	for _, prefix := range r.Prefixes {
-		_, network, _ := net.ParseCIDR(prefix.IP)
-		if network.Contains(net.ParseIP(address)) {
-			return true, nil
+// UnmarshalJSON implements json.Unmarshaler, translating the ip-ranges.json
+// schema (separate ip_prefix/ipv6_prefix tables, a non-standard createDate
+// layout) into the Ranges fields.
+func (r *Ranges) UnmarshalJSON(data []byte) error {
+	var parsed struct {
+		SyncToken    string       `json:"syncToken"`
+		CreateDate   string       `json:"createDate"`
+		IPv4Prefixes []Prefix     `json:"prefixes"`
+		IPv6Prefixes []ipv6Prefix `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+
+	r.SyncToken = parsed.SyncToken
+	if parsed.CreateDate != "" {
+		createDate, err := time.Parse(createDateLayout, parsed.CreateDate)
+		if err != nil {
+			return err
 		}
+		r.CreateDate = createDate
 	}
 
-	return false, nil
+	r.IPv4Prefixes = parsed.IPv4Prefixes
+	r.IPv6Prefixes = make([]Prefix, len(parsed.IPv6Prefixes))
+	for i, p := range parsed.IPv6Prefixes {
+		r.IPv6Prefixes[i] = Prefix{
+			IP:                 p.IP,
+			Region:             p.Region,
+			Service:            p.Service,
+			NetworkBorderGroup: p.NetworkBorderGroup,
+		}
+	}
+
+	r.v4Trie = buildTrie(r.IPv4Prefixes)
+	r.v6Trie = buildTrie(r.IPv6Prefixes)
+
+	return nil
 }
 
-// CheckCIDR checks if a given network is owned by AWS
-func (r *Ranges) CheckCIDR(cidr string) (bool, error) {
-	cidrFirstDigit := cidr[0]
-	for _, prefix := range r.Prefixes {
-		if cidrFirstDigit != prefix.IP[0] {
-			continue
+// buildTrie indexes prefixes into a prefixTrie for enclosing-prefix
+// lookups, skipping any entry whose IP field doesn't parse as a CIDR.
+func buildTrie(prefixes []Prefix) *prefixTrie {
+	trie := newPrefixTrie()
+	for _, prefix := range prefixes {
+		if p, err := netip.ParsePrefix(prefix.IP); err == nil {
+			trie.insert(p, prefix)
 		}
+	}
+	return trie
+}
 
-		if prefix.IP == cidr {
-			return true, nil
-		}
+// emptyTrie is returned by trieFor when a Ranges hasn't been loaded at all
+// yet (e.g. a zero-value Ranges with no prefixes set), so lookups report no
+// match instead of dereferencing a nil trie.
+var emptyTrie = newPrefixTrie()
 
-		ip, _, _ := net.ParseCIDR(cidr)
-		_, prefixNetwork, _ := net.ParseCIDR(prefix.IP)
-		if prefixNetwork.Contains(ip) {
-			return true, nil
-		}
+// trieFor returns the trie matching the IP version of addr.
+func (r *Ranges) trieFor(addr netip.Addr) *prefixTrie {
+	var t *prefixTrie
+	if addr.Is4() {
+		t = r.v4Trie
+	} else {
+		t = r.v6Trie
 	}
-	return false, nil
+	if t == nil {
+		return emptyTrie
+	}
+	return t
+}
+
+// Lookup returns the prefixes of every entry enclosing addr, or nil if addr
+// isn't contained in any AWS range. More than one entry can match the same
+// address (e.g. a region-wide block and a narrower service-specific block).
+func (r *Ranges) Lookup(addr netip.Addr) []Prefix {
+	addr = addr.Unmap()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.trieFor(addr).lookup(addr)
+}
+
+// LookupAll returns every prefix enclosing p as well as every prefix
+// contained within p.
+func (r *Ranges) LookupAll(p netip.Prefix) []Prefix {
+	p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits())
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.trieFor(p.Addr()).lookupAll(p)
+}
+
+// CheckAddress checks if a given address is owned by AWS
+func (r *Ranges) CheckAddress(address string) (bool, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false, err
+	}
+
+	return len(r.Lookup(addr)) > 0, nil
+}
+
+// CheckCIDR checks if a given network is owned by AWS
+func (r *Ranges) CheckCIDR(cidr string) (bool, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return false, err
+	}
+
+	return len(r.Lookup(p.Addr())) > 0, nil
 }
 
 // ServicesResponse contains the region and services assigned to an IP/network
@@ -74,95 +222,294 @@ type ServicesResponse struct {
 
 // CheckServices determines what services and region an IP address is assigned to
 func (r *Ranges) CheckServices(address string) (*ServicesResponse, error) {
-	var answer Prefix
-	var services []string
-	var addressIP net.IP
-	var addressNet *net.IPNet
-	var parseIPError error
+	var matches []Prefix
 
 	if strings.Contains(address, "/") {
-		addressIP, addressNet, parseIPError = net.ParseCIDR(address)
-		if parseIPError != nil {
-			return &ServicesResponse{}, parseIPError
+		p, err := netip.ParsePrefix(address)
+		if err != nil {
+			return &ServicesResponse{}, err
+		}
+		for _, m := range r.LookupAll(p) {
+			if mp, err := netip.ParsePrefix(m.IP); err == nil && mp.Bits() == p.Bits() {
+				matches = append(matches, m)
+			}
 		}
 	} else {
-		addressIP = net.ParseIP(address)
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return &ServicesResponse{}, err
+		}
+		matches = r.Lookup(addr)
+	}
+
+	if len(matches) == 0 {
+		return &ServicesResponse{}, nil
+	}
+
+	services := make([]string, len(matches))
+	for i, m := range matches {
+		services[i] = m.Service
+	}
+
+	return &ServicesResponse{
+		Region:   matches[0].Region,
+		Services: services,
+	}, nil
+}
+
+// New returns a new instance of the Ranges object, refreshed on the default
+// 12h interval.
+func New() (*Ranges, error) {
+	return NewWithOptions(Options{})
+}
+
+// NewFromJSON returns a Ranges loaded from an ip-ranges.json payload already
+// in hand, filtered by opts.Regions/Services/NetworkBorderGroups. Unlike
+// NewWithOptions, it never touches the network or a Cache and doesn't start
+// a background updater, so Close is a no-op. Useful for loading a local
+// snapshot of ip-ranges.json, or in tests.
+func NewFromJSON(data []byte, opts Options) (*Ranges, error) {
+	r := &Ranges{opts: opts}
+	if err := r.setPrefixes(data); err != nil {
+		return nil, err
 	}
+	return r, nil
+}
 
-	for _, prefix := range r.Prefixes {
-		_, prefixNetwork, parseIPError := net.ParseCIDR(prefix.IP)
-		if parseIPError != nil {
-			return &ServicesResponse{}, parseIPError
+// NewWithOptions returns a new instance of the Ranges object configured by
+// opts, and starts a background goroutine that periodically re-fetches
+// ip-ranges.json and hot-swaps the in-memory prefix list. Callers must call
+// Close when done with the Ranges to stop the updater.
+func NewWithOptions(opts Options) (*Ranges, error) {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = defaultRefreshInterval
+	}
+	if opts.MaxCacheAge <= 0 {
+		opts.MaxCacheAge = 2 * opts.RefreshInterval
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = httpClient()
+	}
+	if opts.Cache == nil {
+		fileCache, err := NewFileCache(opts.CacheDir)
+		if err != nil {
+			return nil, err
 		}
-		if addressNet != nil {
-			if !reflect.DeepEqual(prefixNetwork.Mask, addressNet.Mask) {
-				continue
+		opts.Cache = fileCache
+	}
+
+	r := &Ranges{
+		Client:      opts.HTTPClient,
+		opts:        opts,
+		cache:       opts.Cache,
+		stopRefresh: make(chan struct{}),
+	}
+
+	if err := r.refresh(true); err != nil {
+		return nil, err
+	}
+
+	r.wg.Add(1)
+	go r.autoRefresh(r.stopRefresh)
+
+	return r, nil
+}
+
+// Close stops the background updater. It is safe to call more than once.
+func (r *Ranges) Close() error {
+	r.mu.Lock()
+	if r.stopRefresh == nil {
+		r.mu.Unlock()
+		return nil
+	}
+	close(r.stopRefresh)
+	r.stopRefresh = nil
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	return nil
+}
+
+// autoRefresh periodically re-fetches ip-ranges.json until Close is called,
+// i.e. until stop is closed.
+func (r *Ranges) autoRefresh(stop <-chan struct{}) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.opts.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.refresh(false); err != nil {
+				fmt.Fprintf(os.Stderr, "awsranges: background refresh failed: %v\n", err)
 			}
-		}
-		if address == prefix.IP || prefixNetwork.Contains(addressIP) {
-			answer = prefix
-			services = append(services, prefix.Service)
+		case <-stop:
+			return
 		}
 	}
+}
+
+// refresh loads the current ip-ranges.json. On the very first call (force),
+// a cached response within MaxCacheAge is trusted as-is and the next
+// scheduled tick will perform the conditional network refresh; a stale or
+// missing cache on that first call is fetched unconditionally. Every other
+// call always hits the network, conditionally via the ETag/Last-Modified of
+// the last fetch so unchanged responses are cheap.
+func (r *Ranges) refresh(force bool) error {
+	ctx := context.Background()
 
-	if answer.Service != "" {
-		if len(services) > 1 {
-			return &ServicesResponse{
-				Region:   answer.Region,
-				Services: services,
-			}, nil
+	if force {
+		data, meta, err := r.cache.Load(ctx)
+		if err == nil && !r.cacheStale(meta) {
+			r.cacheMeta = meta
+			return r.setPrefixes(data)
 		}
-		return &ServicesResponse{
-			Region:   answer.Region,
-			Services: []string{answer.Service},
-		}, nil
 	}
 
-	return &ServicesResponse{}, nil
+	data, changed, err := r.fetch()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		// The server confirmed our cached copy is still current: bump its
+		// FetchedAt and re-store it so a stale cache doesn't force an
+		// unconditional refresh on the next process start even though
+		// nothing has actually changed in ip-ranges.json.
+		return r.touchCache(ctx)
+	}
+
+	if err := r.setPrefixes(data); err != nil {
+		return err
+	}
+
+	r.cacheMeta.SyncToken = r.SyncToken
+	r.cacheMeta.FetchedAt = time.Now()
+	return r.cache.Store(ctx, data, r.cacheMeta)
 }
 
-// New returns a new instance of the Ranges object
-func New() (*Ranges, error) {
-	u, err := user.Current()
+// touchCache re-stores the cached response with an updated FetchedAt after a
+// 304 Not Modified response confirms it's still current.
+func (r *Ranges) touchCache(ctx context.Context) error {
+	data, meta, err := r.cache.Load(ctx)
 	if err != nil {
-		return nil, err
+		return nil
 	}
 
-	cachedFile := path.Join(u.HomeDir, cacheFileName)
-	useCache := fileExists(cachedFile)
+	meta.FetchedAt = time.Now()
+	r.cacheMeta = meta
+	return r.cache.Store(ctx, data, meta)
+}
 
-	client := httpClient()
-	var ranges Ranges
-	ranges.Client = client
+// cacheStale reports whether a cached response is older than MaxCacheAge (or
+// has no recorded fetch time at all) and should therefore not be trusted
+// without a network refresh.
+func (r *Ranges) cacheStale(meta CacheMeta) bool {
+	if meta.FetchedAt.IsZero() {
+		return true
+	}
+	return time.Since(meta.FetchedAt) > r.opts.MaxCacheAge
+}
 
-	var data []byte
-	if useCache {
-		data, err = readFromCache(cachedFile)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		res, err := ranges.Client.Get(awsRangesURL)
-		if err != nil {
-			return nil, err
-		}
-		defer res.Body.Close()
+// fetch performs a conditional GET against awsRangesURL, honoring the ETag/
+// Last-Modified validators from the last successful fetch. changed is false
+// when the server responded 304 Not Modified.
+func (r *Ranges) fetch() ([]byte, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, awsRangesURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if r.cacheMeta.ETag != "" {
+		req.Header.Set("If-None-Match", r.cacheMeta.ETag)
+	}
+	if r.cacheMeta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", r.cacheMeta.LastModified)
+	}
 
-		data, err = ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, false, nil
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.cacheMeta = CacheMeta{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+
+	return data, true, nil
+}
+
+// setPrefixes unmarshals data into the Ranges and swaps it in under the
+// write lock so concurrent readers never see a partial update.
+func (r *Ranges) setPrefixes(data []byte) error {
+	var parsed Ranges
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	parsed.IPv4Prefixes = r.filterPrefixes(parsed.IPv4Prefixes)
+	parsed.IPv6Prefixes = r.filterPrefixes(parsed.IPv6Prefixes)
+
+	v4Trie := buildTrie(parsed.IPv4Prefixes)
+	v6Trie := buildTrie(parsed.IPv6Prefixes)
+
+	r.mu.Lock()
+	r.IPv4Prefixes = parsed.IPv4Prefixes
+	r.IPv6Prefixes = parsed.IPv6Prefixes
+	r.SyncToken = parsed.SyncToken
+	r.CreateDate = parsed.CreateDate
+	r.v4Trie = v4Trie
+	r.v6Trie = v6Trie
+	r.mu.Unlock()
+	return nil
+}
+
+// filterPrefixes drops any prefix that doesn't match the configured
+// Regions/Services/NetworkBorderGroups filters. A nil or empty filter
+// matches everything.
+func (r *Ranges) filterPrefixes(prefixes []Prefix) []Prefix {
+	if len(r.opts.Regions) == 0 && len(r.opts.Services) == 0 && len(r.opts.NetworkBorderGroups) == 0 {
+		return prefixes
+	}
+
+	filtered := make([]Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if !matchAny(r.opts.Regions, prefix.Region) {
+			continue
 		}
-		err = ioutil.WriteFile(cachedFile, data, 0644)
-		if err != nil {
-			return nil, err
+		if !matchAny(r.opts.Services, prefix.Service) {
+			continue
 		}
+		if !matchAny(r.opts.NetworkBorderGroups, prefix.NetworkBorderGroup) {
+			continue
+		}
+		filtered = append(filtered, prefix)
 	}
+	return filtered
+}
 
-	err = json.Unmarshal(data, &ranges)
-	if err != nil {
-		return nil, err
+// matchAny reports whether value matches any of patterns. Patterns support
+// path.Match-style wildcards (e.g. "us-*"). An empty pattern list matches
+// everything.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
 	}
-	return &ranges, nil
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
 }
 
 func httpClient() *http.Client {
@@ -183,21 +530,3 @@ func httpClient() *http.Client {
 		},
 	}
 }
-
-func fileExists(f string) bool {
-	_, err := os.Stat(f)
-	if os.IsNotExist(err) || err != nil {
-		return false
-	}
-	return true
-}
-
-func readFromCache(cacheFile string) ([]byte, error) {
-	fileReader, err := os.Open(cacheFile)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open cached file: %+v", err)
-	}
-	defer fileReader.Close()
-
-	return ioutil.ReadAll(fileReader)
-}
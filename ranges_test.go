@@ -0,0 +1,179 @@
+package awsranges
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	data := []byte(`{
+		"syncToken": "1234567890",
+		"createDate": "2023-01-02-03-04-05",
+		"prefixes": [
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON", "network_border_group": "ap-northeast-2"}
+		],
+		"ipv6_prefixes": [
+			{"ipv6_prefix": "2600:1ff2::/36", "region": "us-west-2", "service": "EC2", "network_border_group": "us-west-2"}
+		]
+	}`)
+
+	var r Ranges
+	if err := r.UnmarshalJSON(data); err != nil {
+		t.Fatalf("UnmarshalJSON returned an error: %v", err)
+	}
+
+	if r.SyncToken != "1234567890" {
+		t.Errorf("SyncToken = %q, want %q", r.SyncToken, "1234567890")
+	}
+	wantDate := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !r.CreateDate.Equal(wantDate) {
+		t.Errorf("CreateDate = %v, want %v", r.CreateDate, wantDate)
+	}
+
+	if len(r.IPv4Prefixes) != 1 || r.IPv4Prefixes[0].IP != "3.5.140.0/22" || r.IPv4Prefixes[0].Service != "AMAZON" {
+		t.Errorf("IPv4Prefixes = %+v, want a single 3.5.140.0/22 AMAZON entry", r.IPv4Prefixes)
+	}
+
+	if len(r.IPv6Prefixes) != 1 || r.IPv6Prefixes[0].IP != "2600:1ff2::/36" || r.IPv6Prefixes[0].Service != "EC2" {
+		t.Errorf("IPv6Prefixes = %+v, want a single 2600:1ff2::/36 EC2 entry", r.IPv6Prefixes)
+	}
+
+	if ok, err := r.CheckAddress("3.5.140.5"); err != nil || !ok {
+		t.Errorf("CheckAddress(3.5.140.5) after a direct UnmarshalJSON = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := r.CheckAddress("2600:1ff2::1"); err != nil || !ok {
+		t.Errorf("CheckAddress(2600:1ff2::1) after a direct UnmarshalJSON = %v, %v, want true, nil", ok, err)
+	}
+}
+
+func TestJSONUnmarshalBuildsWorkingLookups(t *testing.T) {
+	data := []byte(`{
+		"syncToken": "1",
+		"prefixes": [
+			{"ip_prefix": "3.5.140.0/24", "region": "ap-northeast-2", "service": "EC2"}
+		],
+		"ipv6_prefixes": []
+	}`)
+
+	var r Ranges
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("json.Unmarshal returned an error: %v", err)
+	}
+
+	if ok, err := r.CheckAddress("3.5.140.5"); err != nil || !ok {
+		t.Errorf("CheckAddress(3.5.140.5) after json.Unmarshal = %v, %v, want true, nil", ok, err)
+	}
+}
+
+func TestCheckServicesReturnsAllEnclosingMatches(t *testing.T) {
+	data := []byte(`{
+		"syncToken": "1",
+		"prefixes": [
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+			{"ip_prefix": "3.5.140.0/24", "region": "ap-northeast-2", "service": "EC2"}
+		],
+		"ipv6_prefixes": []
+	}`)
+
+	var r Ranges
+	if err := r.setPrefixes(data); err != nil {
+		t.Fatalf("setPrefixes returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		want    []string
+	}{
+		{"address form, enclosed by both a broad and a narrow CIDR", "3.5.140.5", []string{"AMAZON", "EC2"}},
+		{"CIDR form, matching the narrow entry exactly", "3.5.140.0/24", []string{"EC2"}},
+		{"CIDR form, matching the broad entry exactly", "3.5.140.0/22", []string{"AMAZON"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := r.CheckServices(tt.address)
+			if err != nil {
+				t.Fatalf("CheckServices(%q) returned an error: %v", tt.address, err)
+			}
+			if len(resp.Services) != len(tt.want) {
+				t.Fatalf("CheckServices(%q).Services = %v, want %v", tt.address, resp.Services, tt.want)
+			}
+			for i := range resp.Services {
+				if resp.Services[i] != tt.want[i] {
+					t.Fatalf("CheckServices(%q).Services = %v, want %v", tt.address, resp.Services, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		value    string
+		want     bool
+	}{
+		{"empty patterns match everything", nil, "us-east-1", true},
+		{"exact match", []string{"us-east-1"}, "us-east-1", true},
+		{"no match", []string{"us-east-1"}, "eu-west-1", false},
+		{"wildcard match", []string{"us-*"}, "us-west-2", true},
+		{"wildcard no match", []string{"us-*"}, "eu-west-1", false},
+		{"match among several patterns", []string{"eu-*", "us-*"}, "us-east-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchAny(tt.patterns, tt.value); got != tt.want {
+				t.Errorf("matchAny(%v, %q) = %v, want %v", tt.patterns, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterPrefixes(t *testing.T) {
+	prefixes := []Prefix{
+		{IP: "3.5.140.0/22", Region: "ap-northeast-2", Service: "AMAZON", NetworkBorderGroup: "ap-northeast-2"},
+		{IP: "13.32.0.0/15", Region: "us-east-1", Service: "CLOUDFRONT", NetworkBorderGroup: "us-east-1"},
+		{IP: "52.94.0.0/22", Region: "us-west-2", Service: "EC2", NetworkBorderGroup: "us-west-2"},
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"no filters", Options{}, []string{"3.5.140.0/22", "13.32.0.0/15", "52.94.0.0/22"}},
+		{"region filter", Options{Regions: []string{"us-*"}}, []string{"13.32.0.0/15", "52.94.0.0/22"}},
+		{"service filter", Options{Services: []string{"EC2"}}, []string{"52.94.0.0/22"}},
+		{"region and service filter", Options{Regions: []string{"us-*"}, Services: []string{"EC2"}}, []string{"52.94.0.0/22"}},
+		{"network border group filter", Options{NetworkBorderGroups: []string{"ap-northeast-2"}}, []string{"3.5.140.0/22"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Ranges{opts: tt.opts}
+			got := r.filterPrefixes(prefixes)
+			if len(got) != len(tt.want) {
+				t.Fatalf("filterPrefixes() = %v, want %v", got, tt.want)
+			}
+			for i, p := range got {
+				if p.IP != tt.want[i] {
+					t.Fatalf("filterPrefixes() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONNoCreateDate(t *testing.T) {
+	var r Ranges
+	if err := r.UnmarshalJSON([]byte(`{"syncToken": "1", "prefixes": [], "ipv6_prefixes": []}`)); err != nil {
+		t.Fatalf("UnmarshalJSON returned an error: %v", err)
+	}
+	if !r.CreateDate.IsZero() {
+		t.Errorf("CreateDate = %v, want zero value when createDate is absent", r.CreateDate)
+	}
+}
@@ -0,0 +1,60 @@
+package awsranges
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheStale(t *testing.T) {
+	r := &Ranges{opts: Options{MaxCacheAge: time.Hour}}
+
+	tests := []struct {
+		name string
+		meta CacheMeta
+		want bool
+	}{
+		{"zero FetchedAt", CacheMeta{}, true},
+		{"fresh", CacheMeta{FetchedAt: time.Now()}, false},
+		{"older than MaxCacheAge", CacheMeta{FetchedAt: time.Now().Add(-2 * time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.cacheStale(tt.meta); got != tt.want {
+				t.Errorf("cacheStale(%+v) = %v, want %v", tt.meta, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTouchCacheUpdatesFetchedAt(t *testing.T) {
+	mc := &MemoryCache{}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := mc.Store(context.Background(), []byte(`{}`), CacheMeta{ETag: `"v1"`, FetchedAt: old}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Ranges{cache: mc}
+	if err := r.touchCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, meta, err := mc.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.FetchedAt.After(old) {
+		t.Errorf("FetchedAt = %v, want after %v", meta.FetchedAt, old)
+	}
+	if meta.ETag != `"v1"` {
+		t.Errorf("ETag = %q, want preserved %q", meta.ETag, `"v1"`)
+	}
+}
+
+func TestTouchCacheNoCachedResponse(t *testing.T) {
+	r := &Ranges{cache: &MemoryCache{}}
+	if err := r.touchCache(context.Background()); err != nil {
+		t.Errorf("touchCache on an empty cache returned an error: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package awsranges
+
+import "net/netip"
+
+// prefixTrie is a binary trie over the bits of an IP address, used to find
+// every CIDR in a fixed set that encloses an address. Each node may
+// carry multiple Prefix values, since more than one service/region entry in
+// ip-ranges.json can share the exact same CIDR.
+type prefixTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	prefixes []Prefix
+}
+
+func newPrefixTrie() *prefixTrie {
+	return &prefixTrie{root: &trieNode{}}
+}
+
+// insert adds prefix to the trie under the bits of p.
+func (t *prefixTrie) insert(p netip.Prefix, prefix Prefix) {
+	node := t.root
+	addr := p.Addr().AsSlice()
+	for i := 0; i < p.Bits(); i++ {
+		bit := bitAt(addr, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.prefixes = append(node.prefixes, prefix)
+}
+
+// lookup returns the prefixes of every inserted entry that encloses addr,
+// from least to most specific, or nil if addr isn't contained in any of
+// them. A single address commonly falls inside more than one AWS-announced
+// CIDR at different prefix lengths (e.g. a region-wide block and a
+// service-specific sub-block), so all of them are returned rather than just
+// the most specific match.
+func (t *prefixTrie) lookup(addr netip.Addr) []Prefix {
+	node := t.root
+	b := addr.AsSlice()
+
+	var results []Prefix
+	results = append(results, node.prefixes...)
+
+	for i := 0; i < len(b)*8; i++ {
+		next := node.children[bitAt(b, i)]
+		if next == nil {
+			break
+		}
+		node = next
+		results = append(results, node.prefixes...)
+	}
+
+	return results
+}
+
+// lookupAll returns every prefix enclosing p (ancestors of p's node) as well
+// as every prefix contained within p (the whole subtree rooted at p's node).
+func (t *prefixTrie) lookupAll(p netip.Prefix) []Prefix {
+	node := t.root
+	b := p.Addr().AsSlice()
+
+	var results []Prefix
+	for i := 0; i < p.Bits(); i++ {
+		results = append(results, node.prefixes...)
+		next := node.children[bitAt(b, i)]
+		if next == nil {
+			return results
+		}
+		node = next
+	}
+
+	return node.collect(results)
+}
+
+// collect appends every prefix in the subtree rooted at n to acc.
+func (n *trieNode) collect(acc []Prefix) []Prefix {
+	acc = append(acc, n.prefixes...)
+	for _, c := range n.children {
+		if c != nil {
+			acc = c.collect(acc)
+		}
+	}
+	return acc
+}
+
+// bitAt returns the i-th most-significant bit of b, treated as a single
+// big-endian bitstring.
+func bitAt(b []byte, i int) int {
+	return int((b[i/8] >> (7 - uint(i%8))) & 1)
+}
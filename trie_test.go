@@ -0,0 +1,77 @@
+package awsranges
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func insertCIDR(t *testing.T, trie *prefixTrie, cidr, service string) {
+	t.Helper()
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		t.Fatalf("ParsePrefix(%q): %v", cidr, err)
+	}
+	trie.insert(p, Prefix{IP: cidr, Service: service})
+}
+
+func servicesOf(prefixes []Prefix) []string {
+	services := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		services[i] = p.Service
+	}
+	return services
+}
+
+func TestPrefixTrieLookup(t *testing.T) {
+	trie := newPrefixTrie()
+	insertCIDR(t, trie, "3.5.140.0/22", "AMAZON")
+	insertCIDR(t, trie, "3.5.140.0/24", "EC2")
+	insertCIDR(t, trie, "10.0.0.0/8", "S3")
+
+	tests := []struct {
+		name string
+		addr string
+		want []string
+	}{
+		{"address enclosed by both a broad and a narrow CIDR", "3.5.140.5", []string{"AMAZON", "EC2"}},
+		{"address only in the broad CIDR", "3.5.143.1", []string{"AMAZON"}},
+		{"address in an unrelated CIDR", "10.1.2.3", []string{"S3"}},
+		{"address not in any CIDR", "8.8.8.8", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := netip.MustParseAddr(tt.addr)
+			got := servicesOf(trie.lookup(addr))
+			if len(got) != len(tt.want) {
+				t.Fatalf("lookup(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("lookup(%s) = %v, want %v", tt.addr, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefixTrieLookupAll(t *testing.T) {
+	trie := newPrefixTrie()
+	insertCIDR(t, trie, "3.5.140.0/22", "AMAZON")
+	insertCIDR(t, trie, "3.5.140.0/24", "EC2")
+	insertCIDR(t, trie, "3.5.141.0/24", "S3")
+
+	// Ancestors of the queried prefix are included...
+	got := servicesOf(trie.lookupAll(netip.MustParsePrefix("3.5.140.0/24")))
+	want := []string{"AMAZON", "EC2"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("lookupAll(3.5.140.0/24) = %v, want %v", got, want)
+	}
+
+	// ...and so is everything contained within it.
+	got = servicesOf(trie.lookupAll(netip.MustParsePrefix("3.5.140.0/22")))
+	want = []string{"AMAZON", "EC2", "S3"}
+	if len(got) != len(want) {
+		t.Fatalf("lookupAll(3.5.140.0/22) = %v, want %v", got, want)
+	}
+}